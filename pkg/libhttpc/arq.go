@@ -0,0 +1,303 @@
+package libhttpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/whitetig3r/httpc/pkg/libhttpc/congestion"
+)
+
+const (
+	rtoAlpha = 0.125
+	rtoBeta  = 0.25
+	minRTO   = 200 * time.Millisecond
+	maxRTO   = 60 * time.Second
+
+	// maxRetries bounds how many times a packet may be retransmitted before
+	// the transfer is abandoned as undeliverable.
+	maxRetries = 12
+)
+
+// errMaxRetriesExceeded is returned by UDPGet/UDPPost when a packet goes
+// unacknowledged after maxRetries retransmissions.
+var errMaxRetriesExceeded = errors.New("libhttpc: packet unacknowledged after max retries")
+
+// rtoEstimator computes a smoothed retransmission timeout using the
+// Jacobson/Karn algorithm: SRTT and RTTVAR are updated from fresh RTT
+// samples, and RTO = SRTT + 4*RTTVAR. Samples are never taken from
+// retransmitted packets (Karn's algorithm) since their RTT is ambiguous.
+type rtoEstimator struct {
+	mu      sync.Mutex
+	srtt    time.Duration
+	rttvar  time.Duration
+	rto     time.Duration
+	sampled bool
+}
+
+func newRTOEstimator() *rtoEstimator {
+	return &rtoEstimator{rto: minRTO}
+}
+
+// sample feeds a fresh (non-retransmitted) RTT measurement into the
+// estimator and recomputes RTO.
+func (e *rtoEstimator) sample(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.sampled {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.sampled = true
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = time.Duration((1-rtoBeta)*float64(e.rttvar) + rtoBeta*float64(delta))
+		e.srtt = time.Duration((1-rtoAlpha)*float64(e.srtt) + rtoAlpha*float64(rtt))
+	}
+
+	rto := e.srtt + 4*e.rttvar
+	if rto < minRTO {
+		rto = minRTO
+	}
+	e.rto = rto
+}
+
+// get returns the current RTO.
+func (e *rtoEstimator) get() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rto
+}
+
+// backoff doubles the RTO, capped at maxRTO, for a timeout that occurred
+// without an intervening fresh sample (exponential backoff).
+func (e *rtoEstimator) backoff() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rto *= 2
+	if e.rto > maxRTO {
+		e.rto = maxRTO
+	}
+	return e.rto
+}
+
+// pendingPacket is the Selective-Repeat ARQ state for a single outstanding
+// data packet.
+type pendingPacket struct {
+	seq     uint32
+	bytes   []byte
+	sentAt  time.Time
+	retries int
+	acked   bool
+	resent  bool // true once retransmitted; Karn's algorithm skips RTT sampling for these
+	lost    bool // true once a loss has been reported to cc for this packet
+	timer   *time.Timer
+}
+
+// SelectiveRepeat implements Selective-Repeat ARQ over a batch of packets:
+// every unacknowledged packet carries its own RTO timer and is retransmitted
+// individually on expiry, rather than the whole window being resent as
+// Go-Back-N would. RTO is estimated with Jacobson/Karn and loss events are
+// reported to a congestion.Controller.
+type SelectiveRepeat struct {
+	mu      sync.Mutex
+	pending map[uint32]*pendingPacket
+	rto     *rtoEstimator
+	cc      *congestion.Controller
+	send    func(seq uint32, bytes []byte)
+	failed  chan error
+}
+
+// NewSelectiveRepeat creates a SelectiveRepeat sender. send is called
+// (possibly from a timer goroutine) to put a packet on the wire, both for
+// the initial transmission and every retransmission.
+func NewSelectiveRepeat(cc *congestion.Controller, send func(seq uint32, bytes []byte)) *SelectiveRepeat {
+	return &SelectiveRepeat{
+		pending: make(map[uint32]*pendingPacket),
+		rto:     newRTOEstimator(),
+		cc:      cc,
+		send:    send,
+		failed:  make(chan error, 1),
+	}
+}
+
+// Send registers seq/bytes as in flight, transmits it, and arms its
+// retransmission timer.
+func (s *SelectiveRepeat) Send(seq uint32, bytes []byte) {
+	pp := &pendingPacket{seq: seq, bytes: bytes, sentAt: time.Now()}
+
+	s.mu.Lock()
+	s.pending[seq] = pp
+	s.armTimerLocked(pp)
+	s.mu.Unlock()
+
+	s.send(seq, bytes)
+}
+
+// armTimerLocked schedules pp's retransmission timer. Callers must hold s.mu
+// -- pp.timer is read under the same lock by Ack/Nak, and arming it before
+// pp is ever visible to them closes the window where it could be read nil.
+func (s *SelectiveRepeat) armTimerLocked(pp *pendingPacket) {
+	pp.timer = time.AfterFunc(s.rto.get(), func() { s.onTimeout(pp) })
+}
+
+func (s *SelectiveRepeat) onTimeout(pp *pendingPacket) {
+	s.retransmit(pp)
+}
+
+// Nak processes an explicit negative-acknowledgement for seq, reported by
+// the peer instead of inferred from a timer expiry: it cancels the pending
+// retransmission timer and retransmits immediately through the same path a
+// timeout would, so a NAK and an RTO feed the congestion controller and
+// retry accounting identically.
+func (s *SelectiveRepeat) Nak(seq uint32) {
+	s.mu.Lock()
+	pp, ok := s.pending[seq]
+	if !ok || pp.acked {
+		s.mu.Unlock()
+		return
+	}
+	pp.timer.Stop()
+	s.mu.Unlock()
+
+	s.retransmit(pp)
+}
+
+// retransmit bumps pp's retry count and resends pp's bytes, failing the
+// transfer once maxRetries is exceeded. The first retry of pp reports the
+// loss to the congestion controller (releasing its bytes-in-flight
+// reservation and collapsing cwnd/ssthresh); later retries of the same
+// still-unacked pp are the same loss event, not a new one, so they skip
+// cc.Lost and just resend.
+func (s *SelectiveRepeat) retransmit(pp *pendingPacket) {
+	s.mu.Lock()
+	if pp.acked {
+		s.mu.Unlock()
+		return
+	}
+	pp.retries++
+	size := len(pp.bytes)
+	firstLoss := !pp.lost
+	pp.lost = true
+	if pp.retries > maxRetries {
+		s.mu.Unlock()
+		if firstLoss {
+			s.cc.Lost(size)
+		}
+		select {
+		case s.failed <- errMaxRetriesExceeded:
+		default:
+		}
+		return
+	}
+	pp.resent = true
+	pp.sentAt = time.Now()
+	s.rto.backoff()
+	s.armTimerLocked(pp)
+	s.mu.Unlock()
+
+	if firstLoss {
+		s.cc.Lost(size)
+	}
+	s.send(pp.seq, pp.bytes)
+}
+
+// Ack acknowledges seq: its retransmission timer is cancelled and, per
+// Karn's algorithm, an RTT sample is only fed to the estimator if the
+// packet was never retransmitted. If the packet's loss was already reported
+// to the congestion controller (its bytes-in-flight reservation already
+// released by retransmit), only cwnd is grown here -- releasing the bytes
+// again would double-count them.
+func (s *SelectiveRepeat) Ack(seq uint32) {
+	s.mu.Lock()
+	pp, ok := s.pending[seq]
+	if !ok || pp.acked {
+		s.mu.Unlock()
+		return
+	}
+	pp.acked = true
+	pp.timer.Stop()
+	resent := pp.resent
+	lost := pp.lost
+	sentAt := pp.sentAt
+	size := len(pp.bytes)
+	delete(s.pending, seq)
+	s.mu.Unlock()
+
+	if !resent {
+		s.rto.sample(time.Since(sentAt))
+	}
+	if lost {
+		s.cc.Grow()
+	} else {
+		s.cc.Acked(size)
+	}
+}
+
+// Failed returns a channel that receives errMaxRetriesExceeded once any
+// packet exceeds maxRetries without being acknowledged.
+func (s *SelectiveRepeat) Failed() <-chan error {
+	return s.failed
+}
+
+// Outstanding reports how many packets are still unacknowledged.
+func (s *SelectiveRepeat) Outstanding() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// sackWindow is the number of sequence numbers above the cumulative ACK
+// that the SACK bitmap can represent.
+const sackWindow = 32
+
+// encodeSACK packs a cumulative-ACK sequence number and a bitmap of which of
+// the sackWindow sequence numbers above it have already been received, so a
+// sender can retransmit exactly the missing packets instead of inferring
+// gaps from sequence order alone. received is queried for each candidate
+// seq, so callers can back it with a plain map or a reassembly.Reassembler.
+func encodeSACK(cumulative uint32, received func(seq uint32) bool) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[0:4], cumulative)
+
+	var bitmap uint32
+	for i := uint32(0); i < sackWindow; i++ {
+		if received(cumulative + 1 + i) {
+			bitmap |= 1 << i
+		}
+	}
+	binary.BigEndian.PutUint32(buf[4:8], bitmap)
+	return buf
+}
+
+// decodeSACK unpacks a payload produced by encodeSACK. It returns ok=false
+// if payload is too short to contain a SACK block.
+func decodeSACK(payload []byte) (cumulative uint32, bitmap uint32, ok bool) {
+	if len(payload) < 8 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(payload[0:4]), binary.BigEndian.Uint32(payload[4:8]), true
+}
+
+// AckSACK acknowledges cumulative, plus every sequence number above it that
+// an ACK payload produced by encodeSACK reports as already received, so the
+// sender retransmits exactly what's missing instead of everything above the
+// cumulative ACK. A payload too short to contain a SACK block (ok=false)
+// just leaves the cumulative ACK applied, same as before SACK decoding.
+func (s *SelectiveRepeat) AckSACK(cumulative uint32, payload []byte) {
+	s.Ack(cumulative)
+
+	_, bitmap, ok := decodeSACK(payload)
+	if !ok {
+		return
+	}
+	for i := uint32(0); i < sackWindow; i++ {
+		if bitmap&(1<<i) != 0 {
+			s.Ack(cumulative + 1 + i)
+		}
+	}
+}
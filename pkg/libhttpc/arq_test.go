@@ -0,0 +1,156 @@
+package libhttpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whitetig3r/httpc/pkg/libhttpc/congestion"
+)
+
+func TestRTOEstimatorInitialSample(t *testing.T) {
+	e := newRTOEstimator()
+	e.sample(100 * time.Millisecond)
+
+	if got, want := e.srtt, 100*time.Millisecond; got != want {
+		t.Errorf("srtt after first sample = %v, want %v", got, want)
+	}
+	if got, want := e.rttvar, 50*time.Millisecond; got != want {
+		t.Errorf("rttvar after first sample = %v, want %v", got, want)
+	}
+	if got, want := e.get(), e.srtt+4*e.rttvar; got != want {
+		t.Errorf("rto = %v, want srtt+4*rttvar = %v", got, want)
+	}
+}
+
+func TestRTOEstimatorFloorsAtMinRTO(t *testing.T) {
+	e := newRTOEstimator()
+	e.sample(time.Microsecond)
+
+	if got := e.get(); got != minRTO {
+		t.Errorf("rto for a tiny RTT = %v, want the %v floor", got, minRTO)
+	}
+}
+
+func TestRTOEstimatorBackoffDoublesAndCaps(t *testing.T) {
+	e := newRTOEstimator()
+	e.sample(minRTO)
+	before := e.get()
+
+	if got, want := e.backoff(), before*2; got != want {
+		t.Errorf("backoff() = %v, want %v", got, want)
+	}
+
+	for i := 0; i < 20; i++ {
+		e.backoff()
+	}
+	if got := e.get(); got != maxRTO {
+		t.Errorf("rto after repeated backoff = %v, want cap %v", got, maxRTO)
+	}
+}
+
+func TestSACKRoundTrip(t *testing.T) {
+	received := map[uint32]bool{3: true, 5: true}
+	payload := encodeSACK(2, func(seq uint32) bool { return received[seq] })
+
+	cumulative, bitmap, ok := decodeSACK(payload)
+	if !ok {
+		t.Fatal("decodeSACK: ok = false")
+	}
+	if cumulative != 2 {
+		t.Errorf("cumulative = %v, want 2", cumulative)
+	}
+	for seq := range received {
+		bit := seq - cumulative - 1
+		if bitmap&(1<<bit) == 0 {
+			t.Errorf("bitmap missing received seq %v", seq)
+		}
+	}
+	if bitmap&(1<<(4-2-1)) != 0 {
+		t.Error("bitmap set for seq 4, which was never received")
+	}
+}
+
+func TestSelectiveRepeatAckStopsRetransmit(t *testing.T) {
+	cc := congestion.New(congestion.Reno)
+	var sent []uint32
+	sr := NewSelectiveRepeat(cc, func(seq uint32, _ []byte) { sent = append(sent, seq) })
+
+	sr.Send(1, []byte("payload"))
+	sr.Ack(1)
+
+	if got := sr.Outstanding(); got != 0 {
+		t.Errorf("Outstanding() after Ack = %v, want 0", got)
+	}
+	// A second Ack for the same seq should be a no-op, not a double-release.
+	sr.Ack(1)
+
+	// Waiting past the armed RTO shows the acked packet is never resent.
+	time.Sleep(2 * minRTO)
+	if got := len(sent); got != 1 {
+		t.Errorf("sends after Ack = %v, want 1 (no retransmit)", got)
+	}
+}
+
+func TestSelectiveRepeatNakRetransmits(t *testing.T) {
+	cc := congestion.New(congestion.Reno)
+	var sent []uint32
+	sr := NewSelectiveRepeat(cc, func(seq uint32, _ []byte) { sent = append(sent, seq) })
+
+	sr.Send(1, []byte("payload"))
+	sr.Nak(1)
+
+	if got, want := len(sent), 2; got != want {
+		t.Fatalf("sends after one Nak = %v, want %v (initial + retransmit)", got, want)
+	}
+	if got := sr.Outstanding(); got != 1 {
+		t.Errorf("Outstanding() after Nak = %v, want 1 (still awaiting ack)", got)
+	}
+
+	sr.Ack(1)
+	if got := sr.Outstanding(); got != 0 {
+		t.Errorf("Outstanding() after Ack = %v, want 0", got)
+	}
+}
+
+func TestSelectiveRepeatRetransmitIsOneLossEvent(t *testing.T) {
+	cc := congestion.New(congestion.Reno)
+	sr := NewSelectiveRepeat(cc, func(uint32, []byte) {})
+
+	sr.Send(1, []byte("payload"))
+	ssthreshAfterFirstLoss := func() float64 {
+		sr.Nak(1)
+		return cc.SSThresh()
+	}()
+
+	// A second NAK for the still-unacked packet is the same loss event, not
+	// a new one -- ssthresh/cwnd must not collapse again.
+	sr.Nak(1)
+	if got := cc.SSThresh(); got != ssthreshAfterFirstLoss {
+		t.Errorf("ssthresh after a second NAK of the same packet = %v, want unchanged %v", got, ssthreshAfterFirstLoss)
+	}
+
+	cwndBeforeAck := cc.CWND()
+	sr.Ack(1)
+	if got := cc.CWND(); got <= cwndBeforeAck {
+		t.Errorf("cwnd after the eventual Ack = %v, want > %v (window should still grow)", got, cwndBeforeAck)
+	}
+}
+
+func TestSelectiveRepeatFailsAfterMaxRetries(t *testing.T) {
+	cc := congestion.New(congestion.Reno)
+	sr := NewSelectiveRepeat(cc, func(uint32, []byte) {})
+
+	sr.Send(1, []byte("payload"))
+	for i := 0; i < maxRetries+1; i++ {
+		sr.Nak(1)
+	}
+
+	select {
+	case err := <-sr.Failed():
+		if err != errMaxRetriesExceeded {
+			t.Errorf("Failed() err = %v, want %v", err, errMaxRetriesExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Failed() never received errMaxRetriesExceeded")
+	}
+}
@@ -1,6 +1,7 @@
 package libhttpc
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -11,14 +12,129 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/whitetig3r/httpc/pkg/libhttpc/congestion"
+	"github.com/whitetig3r/httpc/pkg/libhttpc/reassembly"
 )
 
+// Options configures a Client's timeouts, retry limits and congestion
+// strategy.
+type Options struct {
+	// HandshakeTimeout bounds each handshake retransmission attempt.
+	HandshakeTimeout time.Duration
+	// ReadTimeout bounds each read while waiting for response packets.
+	ReadTimeout time.Duration
+	// MaxRetries is carried with the other options but is not read by the
+	// Selective-Repeat sender, which always gives up on a packet after the
+	// package's fixed limit of 12 retransmissions.
+	MaxRetries int
+	// Strategy selects the AIMD loss-recovery behaviour used on a NAK/RTO.
+	Strategy congestion.Strategy
+	// MaxBufferedBytes bounds how many bytes of out-of-order response data
+	// may be held awaiting reassembly, so a broken or malicious peer can't
+	// drive memory unbounded.
+	MaxBufferedBytes int
+}
+
+// DefaultOptions returns the Options used by the package-level UDPGet/UDPPost
+// wrappers.
+func DefaultOptions() Options {
+	return Options{
+		HandshakeTimeout: 2 * time.Second,
+		ReadTimeout:      5 * time.Second,
+		MaxRetries:       maxRetries,
+		Strategy:         congestion.Reno,
+		MaxBufferedBytes: 16 * 1024 * 1024,
+	}
+}
+
+// Client is a reliable-UDP connection: a net.PacketConn paired with the
+// remote address to send to, so the transport can be swapped out (an
+// in-memory pipe for tests, a lossy wrapper for property tests, a real
+// *net.UDPConn) without touching UDPGet/UDPPost.
+type Client struct {
+	conn       net.PacketConn
+	remoteAddr net.Addr
+	opts       Options
+}
+
+// NewClient wraps an existing net.PacketConn/remote address pair as a
+// Client. The caller owns conn and is responsible for closing it.
+func NewClient(conn net.PacketConn, remoteAddr net.Addr, opts Options) *Client {
+	return &Client{conn: conn, remoteAddr: remoteAddr, opts: opts}
+}
+
+// protocolVersion is packed into the high nibble of every packet's first
+// byte, alongside the packet type in the low nibble, so future wire-format
+// changes can be detected rather than silently misparsed.
+const protocolVersion = 1
+
+// pTypeEnd marks the explicit end of a response stream, sent after the last
+// data packet, replacing the old scheme of inferring the packet count from
+// the last byte of the first data packet's payload.
+const pTypeEnd = 5
+
+// Address families carried in the byte immediately following seqNo, so a
+// packet can address either an IPv4 or IPv6 peer.
+const (
+	addrFamilyIPv4 byte = 4
+	addrFamilyIPv6 byte = 6
+
+	ipv4AddrLen = 4
+	ipv6AddrLen = 16
+)
+
+const (
+	seqNoLen = 4
+	portLen  = 2
+	// fixedHeaderLen is every header byte before the variable-length
+	// address: the version+type byte, the sequence number, and the
+	// address-family byte.
+	fixedHeaderLen = 1 + seqNoLen + 1
+
+	// maxPacketSize is the largest packet (header + payload) the transport
+	// will put on the wire.
+	maxPacketSize = 1024
+)
+
+// addrLenForFamily returns the number of raw address bytes carried on the
+// wire for family (defaulting to IPv4 for any unrecognised value).
+func addrLenForFamily(family byte) int {
+	if family == addrFamilyIPv6 {
+		return ipv6AddrLen
+	}
+	return ipv4AddrLen
+}
+
+// headerLenForFamily returns the full header size, in bytes, for a packet
+// addressing family.
+func headerLenForFamily(family byte) int {
+	return fixedHeaderLen + addrLenForFamily(family) + portLen
+}
+
+// mssForFamily returns the maximum payload size for a packet addressing
+// family, so the 1013-byte MSS used with the old fixed 11-byte header
+// shrinks automatically: to 1012 bytes for IPv4 (the added address-family
+// byte) and to 1000 bytes for the larger IPv6 header.
+func mssForFamily(family byte) int {
+	return maxPacketSize - headerLenForFamily(family)
+}
+
+// ParsePacket decodes data into a UDPPacket. The address-family byte
+// immediately following seqNo determines whether peerAddr is a 4- or
+// 16-byte address, so header size is computed per-packet rather than
+// assumed to be 11 bytes.
 func ParsePacket(data []byte) UDPPacket {
-	pType := data[0]
+	pType := data[0] & 0x0F
 	seqNo := data[1:5]
-	peerAddr := data[5:9]
-	peerPort := data[9:11]
-	payload := data[11:]
+
+	family := data[5]
+	addrEnd := 5 + 1 + addrLenForFamily(family)
+	peerAddr := data[5:addrEnd] // family byte followed by the raw address
+
+	portEnd := addrEnd + portLen
+	peerPort := data[addrEnd:portEnd]
+	payload := data[portEnd:]
 
 	return UDPPacket{
 		pType:    []byte{pType},
@@ -29,41 +145,82 @@ func ParsePacket(data []byte) UDPPacket {
 	}
 }
 
-func makePacket(pType uint32, seqNo uint32, parsedURL *url.URL, payload string) UDPPacket {
+// parseHostPort parses parsedURL's host -- an IPv4 dotted-quad, a bracketed
+// IPv6 literal, or a DNS name, with or without an explicit port -- into the
+// wire representation used by makePacket: an address-family byte followed by
+// the raw 4- or 16-byte address, and a big-endian 2-byte port. A DNS name is
+// resolved with net.LookupIP, since the packet carries a literal address for
+// the router to relay to, not a hostname; a missing port defaults to 80, the
+// same default connectHandler uses for the plain-TCP path.
+func parseHostPort(parsedURL *url.URL) (family byte, addrBytes []byte, portBytes []byte, err error) {
+	host := parsedURL.Hostname()
+	portStr := parsedURL.Port()
+	if portStr == BlankString {
+		portStr = "80"
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, lookupErr := net.LookupIP(host)
+		if lookupErr != nil {
+			return 0, nil, nil, fmt.Errorf("libhttpc: could not resolve host %q: %w", host, lookupErr)
+		}
+		if len(ips) == 0 {
+			return 0, nil, nil, fmt.Errorf("libhttpc: host %q resolved to no addresses", host)
+		}
+		ip = ips[0]
+	}
 
-	// pType, one of the following: 0 - Data, 1- ACK, 2 - SYN, 3 - SYN-ACK, 4 - NAK; 1 byte
-	pTypeByte := []byte{byte(pType)}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("libhttpc: invalid port %q: %w", portStr, err)
+	}
+	portBytes = make([]byte, portLen)
+	binary.BigEndian.PutUint16(portBytes, uint16(port))
 
-	// seqNo, for SYN it is the initial pNo during 3WH -- else incremental packet numbers; 4 bytes BE
-	seqNoBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(seqNoBytes, seqNo)
+	if ip4 := ip.To4(); ip4 != nil {
+		return addrFamilyIPv4, append([]byte{addrFamilyIPv4}, ip4...), portBytes, nil
+	}
 
-	// peerAddr, either sender/receiver -- translated by router!; 4 bytes
-	peerAddrBytes := make([]byte, 4)
-	addrSplit := strings.Split(parsedURL.Host, ":")
-	peerAddr := addrSplit[0]
-	peerAddrSplit := strings.Split(peerAddr, ".")
-	for i, section := range peerAddrSplit {
-		intSection, _ := strconv.Atoi(section)
-		peerAddrBytes[i] = byte(intSection)
+	ip6 := ip.To16()
+	if ip6 == nil {
+		return 0, nil, nil, fmt.Errorf("libhttpc: unsupported IP address %q", host)
 	}
+	return addrFamilyIPv6, append([]byte{addrFamilyIPv6}, ip6...), portBytes, nil
+}
 
-	//peerAddrBytes := make([]byte, 4)
-	//binary.BigEndian.PutUint32(peerAddrBytes, peerAddr)
+// makePacket builds a UDPPacket addressed to parsedURL.Host. pType is one of
+// the following: 0 - Data, 1 - ACK, 2 - SYN, 3 - SYN-ACK, 4 - NAK, 5 - End of
+// stream, 6 - FIN. It returns an error rather than silently producing a malformed
+// packet if the host can't be parsed, or a DNS name in it can't be resolved.
+// Building several packets for the same peer (getDataPacketBytes, chunking a
+// request/response) should call makePacketWithHost directly instead, so the
+// host is only resolved once rather than once per packet.
+func makePacket(pType uint32, seqNo uint32, parsedURL *url.URL, payload string) (UDPPacket, error) {
+	_, peerAddrBytes, peerPortBytes, err := parseHostPort(parsedURL)
+	if err != nil {
+		return UDPPacket{}, err
+	}
+	return makePacketWithHost(pType, seqNo, peerAddrBytes, peerPortBytes, payload), nil
+}
 
-	// peerPort, either sender/receiver -- translated by router!; 2 bytes BE
-	peerPortBytes := make([]byte, 2)
-	peerPortInt, _ := strconv.Atoi(addrSplit[1])
-	binary.BigEndian.PutUint16(peerPortBytes, uint16(peerPortInt))
+// makePacketWithHost builds a UDPPacket exactly like makePacket, but takes
+// an already-resolved peer address and port instead of a *url.URL, so
+// resolving a DNS name (parseHostPort's net.LookupIP) isn't repeated for
+// every packet addressed to the same peer.
+func makePacketWithHost(pType uint32, seqNo uint32, peerAddrBytes, peerPortBytes []byte, payload string) UDPPacket {
+	// version+type, packed as (version<<4)|type so a version mismatch can
+	// be detected instead of silently misparsed; 1 byte
+	verTypeByte := byte(protocolVersion<<4) | (byte(pType) & 0x0F)
 
-	// payload; max 1013 bytes
-	// TODO handle size constraints/breaking somehow...
-	payloadBytes := []byte(payload)
+	// seqNo, for SYN it is the initial pNo during 3WH -- else incremental packet numbers; 4 bytes BE
+	seqNoBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(seqNoBytes, seqNo)
 
-	// Packet Size Range: 11 (no payload) to 1024 (full payload)
+	payloadBytes := []byte(payload)
 
 	return UDPPacket{
-		pType:    pTypeByte,
+		pType:    []byte{verTypeByte},
 		seqNo:    seqNoBytes,
 		peerAddr: peerAddrBytes,
 		peerPort: peerPortBytes,
@@ -71,73 +228,85 @@ func makePacket(pType uint32, seqNo uint32, parsedURL *url.URL, payload string)
 	}
 }
 
-func getDataPacketBytes(seqNo uint32, parsedURL *url.URL, payload string) ([][]byte, int) {
-	numPackets := int(math.Ceil(float64(len(payload)) / float64(1013)))
+func getDataPacketBytes(seqNo uint32, parsedURL *url.URL, payload string) ([][]byte, int, error) {
+	family, peerAddrBytes, peerPortBytes, err := parseHostPort(parsedURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	mss := mssForFamily(family)
+
+	numPackets := int(math.Ceil(float64(len(payload)) / float64(mss)))
 	packetsBytes := make([][]byte, numPackets)
 	payloadBytes := []byte(payload)
 
 	if numPackets == 1 {
-		packetBytes := getBytesFromPacket(makePacket(0, seqNo, parsedURL, payload))
-		packetsBytes[0] = packetBytes
-		return packetsBytes, 1
+		packet := makePacketWithHost(0, seqNo, peerAddrBytes, peerPortBytes, payload)
+		packetsBytes[0] = getBytesFromPacket(packet)
+		return packetsBytes, 1, nil
 	}
 
 	counter := 0
 	for i := 1; i < numPackets; i++ {
-		chunk := payloadBytes[counter : counter+1013]
-		packetForChunk := makePacket(0, seqNo, parsedURL, string(chunk))
+		chunk := payloadBytes[counter : counter+mss]
+		packetForChunk := makePacketWithHost(0, seqNo, peerAddrBytes, peerPortBytes, string(chunk))
 		packetsBytes[i-1] = getBytesFromPacket(packetForChunk)
-		counter += 1013
+		counter += mss
 		seqNo++
 	}
-	residue := len(payload) % 1013
+	residue := len(payload) % mss
 	if residue > 0 {
 		residueChunk := payloadBytes[counter:]
-		packetsBytes[numPackets-1] = getBytesFromPacket(makePacket(0, seqNo, parsedURL, string(residueChunk)))
+		lastPacket := makePacketWithHost(0, seqNo, peerAddrBytes, peerPortBytes, string(residueChunk))
+		packetsBytes[numPackets-1] = getBytesFromPacket(lastPacket)
 	}
-	return packetsBytes, numPackets
+	return packetsBytes, numPackets, nil
 }
 
-func handshake(conn *net.UDPConn, parsedURL *url.URL, numPackets int) {
+func handshake(conn net.PacketConn, remoteAddr net.Addr, parsedURL *url.URL, numPackets int, timeout time.Duration) error {
 	for {
-		deadline := time.Now().Add(2 * time.Second)
-		//wTimeoutErr := conn.SetWriteDeadline(deadline)
+		deadline := time.Now().Add(timeout)
 		rTimeoutErr := conn.SetReadDeadline(deadline)
-		//if wTimeoutErr != nil || rTimeoutErr != nil {
 		if rTimeoutErr != nil {
 			fmt.Println("Timing out!")
 		}
 
 		seqInit := uint32(1)
-		packet := makePacket(2, seqInit, parsedURL, fmt.Sprintf("%d", numPackets))
+		packet, err := makePacket(2, seqInit, parsedURL, fmt.Sprintf("%d", numPackets))
+		if err != nil {
+			return err
+		}
 		packetBytes := getBytesFromPacket(packet)
 
-		_, err := conn.Write(packetBytes)
-		if err != nil {
+		if _, err := conn.WriteTo(packetBytes, remoteAddr); err != nil {
 			fmt.Println(err)
 		}
 
-		readBuf := make([]byte, 11)
-		_, _, readErr := conn.ReadFromUDP(readBuf)
+		// Sized for the largest possible header (IPv6); ParsePacket works
+		// out the real header size from the address-family byte, so we
+		// slice to what was actually received rather than assuming 11
+		// bytes.
+		readBuf := make([]byte, maxPacketSize)
+		n, _, readErr := conn.ReadFrom(readBuf)
 		if readErr != nil {
 			fmt.Println("I/O timeout, retransmissing...")
 			continue
 		}
 
-		synAck := ParsePacket(readBuf)
+		synAck := ParsePacket(readBuf[:n])
 		receivedSeq := binary.BigEndian.Uint32(synAck.seqNo)
 		if synAck.pType[0] == 3 && receivedSeq == seqInit+1 {
-			packet = makePacket(1, receivedSeq+1, parsedURL, "")
-			packetBytes = getBytesFromPacket(packet)
-
-			_, err := conn.Write(packetBytes)
+			ackPacket, err := makePacket(1, receivedSeq+1, parsedURL, "")
 			if err != nil {
+				return err
+			}
+			packetBytes = getBytesFromPacket(ackPacket)
+
+			if _, err := conn.WriteTo(packetBytes, remoteAddr); err != nil {
 				fmt.Println(err)
 			}
-			break
-		} else {
-			fmt.Println("Invalid packet type or sequence number, ignoring.")
+			return nil
 		}
+		fmt.Println("Invalid packet type or sequence number, ignoring.")
 	}
 }
 
@@ -149,249 +318,262 @@ func getBytesFromPacket(packet UDPPacket) []byte {
 	return packetBytes
 }
 
-func remove(packetList []UDPPacket, removePack UDPPacket) []UDPPacket {
-	for i, curr := range packetList {
-		if binary.BigEndian.Uint32(curr.seqNo) == binary.BigEndian.Uint32(removePack.seqNo) {
-			return append(packetList[:i], packetList[i+1:]...)
-		}
-	}
-	return packetList
-}
-
+// UDPGet is a thin wrapper that opens a *net.UDPConn sending to the
+// configured router and issues a GET with DefaultOptions. Callers that need to inject
+// a different transport (for tests, loss injection, etc.) should construct
+// a Client directly and call its UDPGet method.
 func UDPGet(inputUrl string, headers RequestHeader) (string, error) {
-	parsedURL, parsedHeaders, conn, err := udpConnectHandler(inputUrl, headers)
-
+	client, parsedURL, parsedHeaders, conn, err := udpConnectHandler(inputUrl, headers)
 	if err != nil {
 		return BlankString, err
 	}
-
 	defer conn.Close()
+
+	return client.UDPGet(parsedURL, parsedHeaders)
+}
+
+// UDPGet issues a GET over c's transport and returns the reassembled
+// response text (status line, headers and body).
+func (c *Client) UDPGet(parsedURL *url.URL, parsedHeaders string) (string, error) {
 	requestString := fmt.Sprintf(
 		"GET %s %s%s%s%s%s",
 		parsedURL.RequestURI(), ProtocolVersion, CRLF,
 		parsedHeaders, CRLF, CRLF)
 
-	packets, numPackets := getDataPacketBytes(4, parsedURL, requestString)
+	packets, numPackets, err := getDataPacketBytes(4, parsedURL, requestString)
+	if err != nil {
+		return BlankString, err
+	}
+	requestEndSeq := uint32(4 + numPackets)
+	requestEndPacket, err := makePacket(pTypeEnd, requestEndSeq, parsedURL, "")
+	if err != nil {
+		return BlankString, err
+	}
+	requestEndBytes := getBytesFromPacket(requestEndPacket)
 
 	// make handshake
-	handshake(conn, parsedURL, numPackets)
-
-	// start a goroutine listener for the ACKs/NAKs
-	packetChan := make(chan UDPPacket)
-
-	var responsePayload []string
-	numOfResponsePackets := -1
-	var nakList []UDPPacket
+	if err := handshake(c.conn, c.remoteAddr, parsedURL, numPackets, c.opts.HandshakeTimeout); err != nil {
+		return BlankString, err
+	}
 
+	// sr drives Selective-Repeat ARQ for the request packets we send below:
+	// each one gets its own RTO timer (Jacobson/Karn) and is retransmitted
+	// individually on expiry or on an explicit NAK from the peer.
+	cc := congestion.New(c.opts.Strategy)
+	sr := NewSelectiveRepeat(cc, func(seq uint32, pktBytes []byte) {
+		if _, writeErr := c.conn.WriteTo(pktBytes, c.remoteAddr); writeErr != nil {
+			fmt.Println(writeErr)
+		}
+	})
+
+	// windowed sender: gate each send on the congestion window so we never
+	// have more than cwnd bytes in flight, and resume as ACKs arrive. This
+	// runs concurrently with the read loop below -- cwnd starts at 1 MSS and
+	// only grows once an ACK is processed there, so reserving synchronously
+	// here would deadlock on the first packet that doesn't fit in the
+	// still-unexpanded window. The request stream closes with a pTypeEnd
+	// marker sent through sr like any other packet, so the server can
+	// actually detect the end of the request instead of waiting on a
+	// datagram that never retransmits.
 	go func() {
-		// if NAKs are dropped, try again...
-		for packet := range packetChan {
-			if packet.pType[0] == 4 {
-				fmt.Println("Handling NAK")
-				missingNo := binary.BigEndian.Uint32(packet.seqNo)
-				missingPacket := packets[int(missingNo)-4]
-				_, err = conn.Write(missingPacket)
-				if err != nil {
-					fmt.Println(err)
-				}
-			}
+		for i, packetBytes := range packets {
+			seq := uint32(4 + i)
+			cc.Reserve(len(packetBytes))
+			sr.Send(seq, packetBytes)
 		}
+		cc.Reserve(len(requestEndBytes))
+		sr.Send(requestEndSeq, requestEndBytes)
 	}()
 
-	for _, packetBytes := range packets {
-		_, err = conn.Write(packetBytes)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}
-
-	var responsePacket UDPPacket
-	var expectedSeqNo uint32
-	expectedSeqNo = 1
-	acks := make([]uint32, 5)
-	naks := make([]uint32, 5)
+	var body bytes.Buffer
+	reassembler := reassembly.New(1, c.opts.MaxBufferedBytes, func(fragment []byte) {
+		body.Write(fragment)
+	})
 
 	for {
-		readBuf := make([]byte, 1024)
-		_, _, readErr := conn.ReadFromUDP(readBuf)
-		responsePacket = ParsePacket(readBuf)
+		readBuf := make([]byte, maxPacketSize)
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+		n, _, readErr := c.conn.ReadFrom(readBuf)
+
+		select {
+		case failErr := <-sr.Failed():
+			return BlankString, failErr
+		default:
+		}
+
 		if readErr != nil {
 			continue
 		}
+		responsePacket := ParsePacket(readBuf[:n])
 
-		if responsePacket.pType[0] == 1 || responsePacket.pType[0] == 4 {
-			select {
-			case packetChan <- responsePacket:
-			default:
-				// loop again
-			}
+		if responsePacket.pType[0] == 1 {
+			sr.AckSACK(binary.BigEndian.Uint32(responsePacket.seqNo), responsePacket.payload)
+		} else if responsePacket.pType[0] == 4 {
+			fmt.Println("Handling NAK")
+			sr.Nak(binary.BigEndian.Uint32(responsePacket.seqNo))
 		}
 
-		if responsePacket.pType[0] == 4 {
-			nakList = append(nakList, responsePacket)
-		} else if responsePacket.pType[0] == 1 {
-			nakList = remove(nakList, responsePacket)
-		}
+		responseSeq := binary.BigEndian.Uint32(responsePacket.seqNo)
 
-		if responsePacket.pType[0] == 0 {
-			if numOfResponsePackets == -1 {
-				numOfResponsePackets = int(responsePacket.payload[len(responsePacket.payload)-1])
-				if numOfResponsePackets == 0 {
-					numOfResponsePackets = 1
-				}
-				responsePayload = make([]string, numOfResponsePackets+5)
-			}
-			responseSeq := binary.BigEndian.Uint32(responsePacket.seqNo)
-
-			responsePacketPayloadLength := len(responsePacket.payload) - 1
-			responseSlice := responsePacket.payload[0 : responsePacketPayloadLength-1]
-			responsePayload[responseSeq] = string(responseSlice)
-			acks = append(acks, responseSeq)
-			if responseSeq == expectedSeqNo {
-				ackPacket := makePacket(4, responseSeq, parsedURL, "")
-				packetBytes := getBytesFromPacket(ackPacket)
-				_, writeErr := conn.Write(packetBytes)
-				if writeErr != nil {
-					fmt.Println("Timeout writing ACK!")
-				}
-				//fmt.Println(fmt.Sprintf("ACK'd packet %d", responseSeq))
-				expectedSeqNo++
-			} else if responseSeq < expectedSeqNo {
-				// SEND ACK
-				ackPacket := makePacket(4, responseSeq, parsedURL, "")
-				packetBytes := getBytesFromPacket(ackPacket)
-				_, writeErr := conn.Write(packetBytes)
-				if writeErr != nil {
-					fmt.Println("Timeout writing ACK!")
-				}
-				//fmt.Println(fmt.Sprintf("ACK'd packet %d", responseSeq))
-			} else {
-				for packetNum := expectedSeqNo; packetNum < responseSeq; packetNum++ {
-					naks = append(naks, packetNum)
-					nakPacket := makePacket(4, packetNum, parsedURL, "")
-					packetBytes := getBytesFromPacket(nakPacket)
-					_, writeErr := conn.Write(packetBytes)
-					if writeErr != nil {
-						fmt.Println("Timeout writing NAKs!")
-					}
-					//fmt.Println(fmt.Sprintf("NAK'd packet %d", packetNum))
-				}
-				expectedSeqNo = responseSeq + 1
-			}
-			if numOfResponsePackets == 1 {
-				return responsePayload[responseSeq], nil
-			} else if checkNotEmpty(responsePayload[1 : numOfResponsePackets+1]) {
-				return stringifiedResponse(responsePayload[1 : numOfResponsePackets+1]), nil
+		switch responsePacket.pType[0] {
+		case 0:
+			if _, addErr := reassembler.Add(responseSeq, responsePacket.payload); addErr != nil {
+				return BlankString, addErr
 			}
+		case pTypeEnd:
+			reassembler.Complete(responseSeq)
+		default:
+			continue
+		}
 
+		// ACK carries the cumulative sequence number plus a SACK bitmap
+		// of already-received packets above it, so the peer can
+		// retransmit exactly what's missing instead of inferring gaps.
+		// The end marker itself sits above the data stream's sequence
+		// space, so it's acked by its own seq rather than the cumulative
+		// data seq below it.
+		ackSeq := reassembler.NextExpected() - 1
+		if responsePacket.pType[0] == pTypeEnd {
+			ackSeq = responseSeq
+		}
+		ackPacket, ackErr := makePacket(1, ackSeq, parsedURL, string(encodeSACK(ackSeq, reassembler.Received)))
+		if ackErr != nil {
+			fmt.Println(ackErr)
+		} else if _, writeErr := c.conn.WriteTo(getBytesFromPacket(ackPacket), c.remoteAddr); writeErr != nil {
+			fmt.Println("Timeout writing ACK!")
 		}
-	}
-}
 
-func stringifiedResponse(responsePayload []string) string {
-	response := ""
-	for _, stringifiedPacket := range responsePayload {
-		response += stringifiedPacket
+		if reassembler.Done() {
+			return body.String(), nil
+		}
 	}
-	return response
 }
 
+// UDPPost is a thin wrapper that opens a *net.UDPConn sending to the
+// configured router and issues a POST with DefaultOptions. Callers that need to inject
+// a different transport should construct a Client directly and call its
+// UDPPost method.
 func UDPPost(inputUrl string, headers RequestHeader, body []byte) (string, error) {
 	headers["Content-Length"] = fmt.Sprintf("%d", len(body))
-	parsedURL, parsedHeaders, conn, err := udpConnectHandler(inputUrl, headers)
-
+	client, parsedURL, parsedHeaders, conn, err := udpConnectHandler(inputUrl, headers)
 	if err != nil {
 		return BlankString, err
 	}
-
 	defer conn.Close()
 
+	return client.UDPPost(parsedURL, parsedHeaders, body)
+}
+
+// UDPPost issues a POST over c's transport and returns the reassembled
+// response text (status line, headers and body).
+func (c *Client) UDPPost(parsedURL *url.URL, parsedHeaders string, body []byte) (string, error) {
 	requestString := fmt.Sprintf("POST %s %s%s%s%s%s%s",
 		parsedURL.RequestURI(), ProtocolVersion, CRLF,
 		parsedHeaders, CRLF, body, CRLF)
 
-	packets, numPackets := getDataPacketBytes(4, parsedURL, requestString)
-
-	// ack packets
-	ackPackets := make([]UDPPacket, numPackets)
-	for i, copyPacket := range packets {
-		ackPackets[i] = ParsePacket(copyPacket)
+	packets, numPackets, err := getDataPacketBytes(4, parsedURL, requestString)
+	if err != nil {
+		return BlankString, err
+	}
+	requestEndSeq := uint32(4 + numPackets)
+	requestEndPacket, err := makePacket(pTypeEnd, requestEndSeq, parsedURL, "")
+	if err != nil {
+		return BlankString, err
 	}
+	requestEndBytes := getBytesFromPacket(requestEndPacket)
 
 	// make handshake
-	handshake(conn, parsedURL, numPackets)
-
-	// start a goroutine listener for the ACKs/NAKs
-	packetChan := make(chan UDPPacket, 1024)
+	if err := handshake(c.conn, c.remoteAddr, parsedURL, numPackets, c.opts.HandshakeTimeout); err != nil {
+		return BlankString, err
+	}
 
+	// sr drives Selective-Repeat ARQ for the request packets we send below:
+	// each one gets its own RTO timer (Jacobson/Karn) and is retransmitted
+	// individually on expiry or on an explicit NAK from the peer.
+	cc := congestion.New(c.opts.Strategy)
+	sr := NewSelectiveRepeat(cc, func(seq uint32, pktBytes []byte) {
+		if _, writeErr := c.conn.WriteTo(pktBytes, c.remoteAddr); writeErr != nil {
+			fmt.Println(writeErr)
+		}
+	})
+
+	// windowed sender: gate each send on the congestion window so we never
+	// have more than cwnd bytes in flight, and resume as ACKs arrive. This
+	// runs concurrently with the read loop below -- cwnd starts at 1 MSS and
+	// only grows once an ACK is processed there, so reserving synchronously
+	// here would deadlock on the first packet that doesn't fit in the
+	// still-unexpanded window. The request stream closes with a pTypeEnd
+	// marker sent through sr like any other packet, so the server can
+	// actually detect the end of the request instead of waiting on a
+	// datagram that never retransmits.
 	go func() {
-		var nakList []UDPPacket
+		for i, packetBytes := range packets {
+			seq := uint32(4 + i)
+			cc.Reserve(len(packetBytes))
+			sr.Send(seq, packetBytes)
+		}
+		cc.Reserve(len(requestEndBytes))
+		sr.Send(requestEndSeq, requestEndBytes)
+	}()
 
-		for packet := range packetChan {
-			if packet.pType[0] == 4 {
-				nakList = append(nakList, packet)
-			} else if packet.pType[0] == 1 {
-				nakList = remove(nakList, packet)
-			}
+	var respBody bytes.Buffer
+	reassembler := reassembly.New(1, c.opts.MaxBufferedBytes, func(fragment []byte) {
+		respBody.Write(fragment)
+	})
 
-			for _, nakPack := range nakList {
-				fmt.Println("Sending NAK!")
-				missingNo := binary.BigEndian.Uint32(nakPack.seqNo)
-				missingPacket := packets[int(missingNo)-4]
-				_, err = conn.Write(missingPacket)
-				if err != nil {
-					fmt.Println(err)
-				}
-			}
+	for {
+		readBuf := make([]byte, maxPacketSize)
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+		n, _, readErr := c.conn.ReadFrom(readBuf)
+
+		select {
+		case failErr := <-sr.Failed():
+			return BlankString, failErr
+		default:
 		}
-	}()
 
-	for _, packetBytes := range packets {
-		_, err = conn.Write(packetBytes)
-		if err != nil {
-			fmt.Println(err)
+		if readErr != nil {
+			continue
 		}
-	}
-	var responsePacket UDPPacket
+		responsePacket := ParsePacket(readBuf[:n])
 
-	for {
-		readBuf := make([]byte, 1024)
-		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-		_, _, err = conn.ReadFromUDP(readBuf)
-		responsePacket = ParsePacket(readBuf)
+		if responsePacket.pType[0] == 1 {
+			sr.AckSACK(binary.BigEndian.Uint32(responsePacket.seqNo), responsePacket.payload)
+		} else if responsePacket.pType[0] == 4 {
+			sr.Nak(binary.BigEndian.Uint32(responsePacket.seqNo))
+		}
 
-		if err != nil {
-			// retransmission of packets not ACK'd
-			for _, lostPacket := range ackPackets {
-				fmt.Println("Sending lost packet!")
-				_, err = conn.Write(getBytesFromPacket(lostPacket))
-				if err != nil {
-					fmt.Println(err)
-				}
+		responseSeq := binary.BigEndian.Uint32(responsePacket.seqNo)
+
+		switch responsePacket.pType[0] {
+		case 0:
+			if _, addErr := reassembler.Add(responseSeq, responsePacket.payload); addErr != nil {
+				return BlankString, addErr
 			}
+		case pTypeEnd:
+			reassembler.Complete(responseSeq)
+		default:
 			continue
 		}
 
-		if responsePacket.pType[0] == 1 || responsePacket.pType[0] == 4 {
-			if responsePacket.pType[0] == 1 {
-				ackPackets = remove(ackPackets, responsePacket)
-			}
-			select {
-			case packetChan <- responsePacket:
-				// do nothing
-				packetChan <- responsePacket
-			default:
-				// buffer is full
-				//fmt.Println("channel buffer overflow!")
-			}
+		// The end marker sits above the data stream's sequence space, so
+		// it's acked by its own seq rather than the cumulative data seq
+		// below it.
+		ackSeq := reassembler.NextExpected() - 1
+		if responsePacket.pType[0] == pTypeEnd {
+			ackSeq = responseSeq
+		}
+		ackPacket, ackErr := makePacket(1, ackSeq, parsedURL, string(encodeSACK(ackSeq, reassembler.Received)))
+		if ackErr != nil {
+			fmt.Println(ackErr)
+		} else if _, writeErr := c.conn.WriteTo(getBytesFromPacket(ackPacket), c.remoteAddr); writeErr != nil {
+			fmt.Println("Timeout writing ACK!")
 		}
 
-		if responsePacket.pType[0] == 0 {
-			break
+		if reassembler.Done() {
+			return respBody.String(), nil
 		}
 	}
-
-	return string(responsePacket.payload), nil
 }
 
 func Get(inputUrl string, headers RequestHeader) (string, error) {
@@ -545,7 +727,12 @@ func readResponseFromConnection(conn net.Conn) ([]byte, error) {
 	return data, nil
 }
 
-func udpConnectHandler(inputUrl string, headers RequestHeader) (*url.URL, string, *net.UDPConn, error) {
+// udpConnectHandler resolves inputUrl/headers and opens a *net.UDPConn bound
+// to an ephemeral local port, wrapping it as a Client that sends to the
+// configured router via net.PacketConn/WriteTo rather than a connected
+// socket. This is what lets UDPGet/UDPPost run unmodified against any other
+// net.PacketConn (an in-memory pipe, a lossy wrapper, ...) via NewClient.
+func udpConnectHandler(inputUrl string, headers RequestHeader) (*Client, *url.URL, string, net.PacketConn, error) {
 	parsedURL, urlErr := url.Parse(inputUrl)
 	parsedHeaders := stringifyHeaders(headers)
 
@@ -554,13 +741,18 @@ func udpConnectHandler(inputUrl string, headers RequestHeader) (*url.URL, string
 	}
 
 	host := fmt.Sprintf("%s:%s", RouterAddr, RouterPort)
-	hostUdpAddr, err := net.ResolveUDPAddr("udp", host)
+	remoteAddr, err := net.ResolveUDPAddr("udp", host)
 	if err != nil {
 		fmt.Println(err)
 	}
-	conn, err := net.DialUDP("udp", nil, hostUdpAddr)
 
-	return parsedURL, parsedHeaders, conn, err
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, parsedURL, parsedHeaders, nil, err
+	}
+
+	client := NewClient(conn, remoteAddr, DefaultOptions())
+	return client, parsedURL, parsedHeaders, conn, nil
 }
 
 func connectHandler(inputUrl string, headers RequestHeader) (*url.URL, string, net.Conn, error) {
@@ -589,12 +781,3 @@ func stringifyHeaders(headers RequestHeader) string {
 	}
 	return headersString
 }
-
-func checkNotEmpty(responsePayload []string) bool {
-	for _, packet := range responsePayload {
-		if len(packet) == 0 {
-			return false
-		}
-	}
-	return true
-}
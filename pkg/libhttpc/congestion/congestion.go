@@ -0,0 +1,159 @@
+// Package congestion implements AIMD congestion control with slow start
+// for the reliable-UDP transport used by libhttpc. It is transport-agnostic:
+// callers report sends, ACKs and loss events and the Controller tracks the
+// congestion window accordingly.
+package congestion
+
+import "sync"
+
+// Strategy selects how the congestion window reacts to a loss event (a NAK
+// or a retransmission timeout).
+type Strategy int
+
+const (
+	// Reno drops cwnd to ssthresh on loss, skipping slow start.
+	Reno Strategy = iota
+	// Tahoe drops cwnd all the way back to 1 MSS on loss, re-entering
+	// slow start.
+	Tahoe
+)
+
+// MSS is the segment size, in bytes, used as the unit of cwnd and ssthresh
+// growth: the payload of a full packet under libhttpc's original 11-byte
+// header. It is not recomputed per address family; libhttpc's current packets
+// carry at most 1012 (IPv4) or 1000 (IPv6) payload bytes, and are reserved by
+// their full on-wire length of up to 1024 bytes.
+const MSS = 1013
+
+// defaultSsthresh is the initial slow-start threshold, chosen generously so
+// a fresh connection spends its first few RTTs in slow start.
+const defaultSsthresh = 64 * MSS
+
+// Controller tracks a congestion window (cwnd) and slow-start threshold
+// (ssthresh) for a single connection, and gates how many bytes may be in
+// flight at once. It is safe for concurrent use.
+type Controller struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	strategy Strategy
+
+	cwnd     float64
+	ssthresh float64
+
+	bytesInFlight int
+}
+
+// New creates a Controller in slow start with cwnd = 1 MSS.
+func New(strategy Strategy) *Controller {
+	c := &Controller{
+		strategy: strategy,
+		cwnd:     MSS,
+		ssthresh: defaultSsthresh,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Reserve blocks until bytesInFlight+size would not exceed cwnd, then
+// reserves size bytes as in flight. A send is always admitted when nothing is
+// in flight, so a packet larger than cwnd cannot block forever. Callers must
+// call Acked or Lost exactly once per reserved send to release the
+// reservation.
+func (c *Controller) Reserve(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.bytesInFlight > 0 && float64(c.bytesInFlight+size) > c.cwnd {
+		c.cond.Wait()
+	}
+	c.bytesInFlight += size
+}
+
+// Acked reports that size bytes previously passed to Reserve were
+// successfully delivered, growing cwnd per the AIMD rules: additively by one
+// MSS per ACK in slow start, or by MSS*MSS/cwnd per ACK in congestion
+// avoidance.
+func (c *Controller) Acked(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bytesInFlight -= size
+	if c.bytesInFlight < 0 {
+		c.bytesInFlight = 0
+	}
+
+	if c.cwnd < c.ssthresh {
+		c.cwnd += MSS
+	} else {
+		c.cwnd += MSS * MSS / c.cwnd
+	}
+	c.cond.Broadcast()
+}
+
+// Grow applies the same AIMD cwnd-growth rule as Acked -- additively by one
+// MSS per ACK in slow start, or by MSS*MSS/cwnd per ACK in congestion
+// avoidance -- without touching bytesInFlight. It's for an ACK of a packet
+// whose bytes were already released by an earlier Lost, so releasing them
+// again would double-count.
+func (c *Controller) Grow() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cwnd < c.ssthresh {
+		c.cwnd += MSS
+	} else {
+		c.cwnd += MSS * MSS / c.cwnd
+	}
+	c.cond.Broadcast()
+}
+
+// Lost reports a NAK or retransmission-timeout loss event for a packet of
+// size bytes: its bytes-in-flight reservation is released, since the caller
+// is about to retransmit it as a fresh send rather than keep it outstanding,
+// and ssthresh is set to max(cwnd/2, 2*MSS); cwnd drops to 1 MSS under Tahoe
+// or to the new ssthresh under Reno. Callers must report a given packet's
+// loss at most once -- repeated retries of the same packet are one loss
+// event, not one per retry.
+func (c *Controller) Lost(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bytesInFlight -= size
+	if c.bytesInFlight < 0 {
+		c.bytesInFlight = 0
+	}
+
+	newSsthresh := c.cwnd / 2
+	if newSsthresh < 2*MSS {
+		newSsthresh = 2 * MSS
+	}
+	c.ssthresh = newSsthresh
+
+	if c.strategy == Tahoe {
+		c.cwnd = MSS
+	} else {
+		c.cwnd = c.ssthresh
+	}
+	c.cond.Broadcast()
+}
+
+// CWND returns the current congestion window, in bytes.
+func (c *Controller) CWND() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cwnd
+}
+
+// SSThresh returns the current slow-start threshold, in bytes.
+func (c *Controller) SSThresh() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ssthresh
+}
+
+// InSlowStart reports whether the controller is currently in slow start
+// (cwnd < ssthresh) as opposed to congestion avoidance.
+func (c *Controller) InSlowStart() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cwnd < c.ssthresh
+}
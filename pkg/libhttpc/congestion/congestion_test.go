@@ -0,0 +1,93 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowStartGrowsAdditively(t *testing.T) {
+	c := New(Reno)
+	if !c.InSlowStart() {
+		t.Fatal("a fresh controller should start in slow start")
+	}
+
+	cwndBefore := c.CWND()
+	c.Acked(MSS)
+	if got, want := c.CWND(), cwndBefore+MSS; got != want {
+		t.Errorf("cwnd after one slow-start ACK = %v, want %v", got, want)
+	}
+}
+
+func TestCongestionAvoidanceGrowsSublinearly(t *testing.T) {
+	c := New(Reno)
+	// Force congestion avoidance: under Reno a loss sets cwnd equal to the
+	// new ssthresh.
+	c.Lost(MSS)
+	cwnd := c.CWND()
+
+	c.Acked(MSS)
+	want := cwnd + MSS*MSS/cwnd
+	if got := c.CWND(); got != want {
+		t.Errorf("cwnd after one congestion-avoidance ACK = %v, want %v", got, want)
+	}
+	if c.InSlowStart() {
+		t.Error("cwnd should not be back in slow start after a loss")
+	}
+}
+
+func TestLostRenoDropsToSSThresh(t *testing.T) {
+	c := New(Reno)
+
+	c.Lost(MSS)
+	if got, want := c.SSThresh(), float64(2*MSS); got != want {
+		t.Errorf("ssthresh after loss = %v, want %v", got, want)
+	}
+	if got := c.CWND(); got != c.SSThresh() {
+		t.Errorf("Reno cwnd after loss = %v, want ssthresh %v", got, c.SSThresh())
+	}
+}
+
+func TestLostTahoeDropsToOneMSS(t *testing.T) {
+	c := New(Tahoe)
+
+	c.Lost(MSS)
+	if got := c.CWND(); got != MSS {
+		t.Errorf("Tahoe cwnd after loss = %v, want %v", got, MSS)
+	}
+}
+
+func TestLostReleasesBytesInFlight(t *testing.T) {
+	// Tahoe keeps cwnd at 1 MSS after the loss, so the second Reserve cannot
+	// fit unless the first reservation is released.
+	c := New(Tahoe)
+	c.Reserve(MSS)
+
+	done := make(chan struct{})
+	go func() {
+		c.Reserve(MSS) // only fits once the lost packet's reservation is released
+		close(done)
+	}()
+
+	c.Lost(MSS)
+	<-done
+}
+
+func TestReserveBlocksUntilAcked(t *testing.T) {
+	c := New(Reno)
+	c.Reserve(MSS) // fills the initial 1-MSS window
+
+	unblocked := make(chan struct{})
+	go func() {
+		c.Reserve(MSS)
+		close(unblocked)
+	}()
+
+	select {
+	case <-unblocked:
+		t.Fatal("Reserve returned before the window had room")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	c.Acked(MSS)
+	<-unblocked
+}
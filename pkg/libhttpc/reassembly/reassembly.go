@@ -0,0 +1,112 @@
+// Package reassembly buffers out-of-order stream fragments and flushes
+// contiguous runs to a consumer as they close up, the way gopacket's
+// reassembly package does for TCP streams. It is transport-neutral: callers
+// feed it (seq, payload) fragments and an explicit end-of-stream signal.
+package reassembly
+
+import "errors"
+
+// ErrBufferFull is returned by Add when accepting a fragment would push the
+// buffered out-of-order bytes over the configured cap.
+var ErrBufferFull = errors.New("reassembly: max buffered bytes exceeded")
+
+// Reassembler buffers fragments keyed by sequence number and flushes
+// contiguous runs, starting at the seq passed to New, to a consumer
+// callback. Duplicates of already-flushed sequence numbers are rejected
+// rather than re-flushed, and a configurable cap on buffered bytes bounds
+// how much out-of-order data a broken or malicious peer can force into
+// memory.
+type Reassembler struct {
+	nextExpected  uint32
+	pending       map[uint32][]byte
+	bufferedBytes int
+	maxBuffered   int
+	consume       func([]byte)
+	end           uint32
+	endSeen       bool
+	done          bool
+}
+
+// New creates a Reassembler expecting fragments starting at seq start. consume
+// is called, in order, with each contiguous run of payload bytes as it
+// closes up. maxBufferedBytes bounds how many bytes of not-yet-contiguous
+// fragments may be held at once.
+func New(start uint32, maxBufferedBytes int, consume func([]byte)) *Reassembler {
+	return &Reassembler{
+		nextExpected: start,
+		pending:      make(map[uint32][]byte),
+		maxBuffered:  maxBufferedBytes,
+		consume:      consume,
+	}
+}
+
+// Add accepts a fragment at seq. accepted is false if seq is a duplicate --
+// either already flushed or already buffered -- which is not an error.
+// ErrBufferFull is returned if accepting payload would exceed maxBuffered.
+func (r *Reassembler) Add(seq uint32, payload []byte) (accepted bool, err error) {
+	if seq < r.nextExpected {
+		return false, nil
+	}
+	if _, ok := r.pending[seq]; ok {
+		return false, nil
+	}
+	// An in-order fragment flushes immediately, so only out-of-order ones
+	// count against the cap.
+	if seq != r.nextExpected && r.bufferedBytes+len(payload) > r.maxBuffered {
+		return false, ErrBufferFull
+	}
+
+	r.pending[seq] = payload
+	r.bufferedBytes += len(payload)
+
+	for {
+		frag, ok := r.pending[r.nextExpected]
+		if !ok {
+			break
+		}
+		r.consume(frag)
+		delete(r.pending, r.nextExpected)
+		r.bufferedBytes -= len(frag)
+		r.nextExpected++
+	}
+	if r.endSeen && r.nextExpected >= r.end {
+		r.done = true
+	}
+	return true, nil
+}
+
+// Complete marks the stream finished once an explicit end-of-stream marker
+// has been observed at sequence number end, used instead of inferring
+// completion from a magic length byte. It only takes effect once every
+// sequence number below end has actually been flushed; a peer that signals
+// completion before the gaps close is simply not yet done. end is remembered,
+// so Done becomes true as soon as a later Add closes the last gap.
+func (r *Reassembler) Complete(end uint32) {
+	r.end = end
+	r.endSeen = true
+	if r.nextExpected >= end {
+		r.done = true
+	}
+}
+
+// Done reports whether Complete has been satisfied.
+func (r *Reassembler) Done() bool {
+	return r.done
+}
+
+// NextExpected returns the next sequence number not yet flushed -- the
+// cumulative ACK a caller driving this Reassembler over a network should
+// advertise.
+func (r *Reassembler) NextExpected() uint32 {
+	return r.nextExpected
+}
+
+// Received reports whether seq has already been flushed or is currently
+// buffered awaiting earlier fragments, for building a SACK bitmap.
+func (r *Reassembler) Received(seq uint32) bool {
+	if seq < r.nextExpected {
+		return true
+	}
+	_, ok := r.pending[seq]
+	return ok
+}
@@ -0,0 +1,120 @@
+package reassembly
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInOrderFlushesImmediately(t *testing.T) {
+	var out bytes.Buffer
+	r := New(1, 1024, func(fragment []byte) { out.Write(fragment) })
+
+	if _, err := r.Add(1, []byte("hello ")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Add(2, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "hello world"; got != want {
+		t.Errorf("flushed = %q, want %q", got, want)
+	}
+	if got, want := r.NextExpected(), uint32(3); got != want {
+		t.Errorf("NextExpected() = %v, want %v", got, want)
+	}
+}
+
+func TestOutOfOrderBuffersThenFlushes(t *testing.T) {
+	var out bytes.Buffer
+	r := New(1, 1024, func(fragment []byte) { out.Write(fragment) })
+
+	if _, err := r.Add(2, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "" {
+		t.Errorf("fragment 2 should stay buffered, got flush %q", got)
+	}
+
+	if _, err := r.Add(1, []byte("hello ")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "hello world"; got != want {
+		t.Errorf("flushed = %q, want %q", got, want)
+	}
+}
+
+func TestDuplicateRejected(t *testing.T) {
+	var out bytes.Buffer
+	r := New(1, 1024, func(fragment []byte) { out.Write(fragment) })
+
+	if accepted, err := r.Add(1, []byte("hello")); err != nil || !accepted {
+		t.Fatalf("first Add: accepted=%v err=%v", accepted, err)
+	}
+
+	// Already flushed.
+	if accepted, err := r.Add(1, []byte("hello")); err != nil || accepted {
+		t.Errorf("re-delivered flushed seq: accepted=%v err=%v, want accepted=false", accepted, err)
+	}
+
+	if _, err := r.Add(3, []byte("!")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already buffered.
+	if accepted, err := r.Add(3, []byte("!")); err != nil || accepted {
+		t.Errorf("re-delivered buffered seq: accepted=%v err=%v, want accepted=false", accepted, err)
+	}
+
+	if got, want := out.String(), "hello"; got != want {
+		t.Errorf("flushed = %q, want %q", got, want)
+	}
+}
+
+func TestMaxBufferedBytesExceeded(t *testing.T) {
+	r := New(1, 4, func([]byte) {})
+
+	if _, err := r.Add(2, []byte("toolong")); err != ErrBufferFull {
+		t.Errorf("Add() err = %v, want ErrBufferFull", err)
+	}
+}
+
+func TestCompleteOnlyOnceContiguous(t *testing.T) {
+	r := New(1, 1024, func([]byte) {})
+
+	if _, err := r.Add(2, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	r.Complete(3)
+	if r.Done() {
+		t.Fatal("Done() should be false while seq 1 is still missing")
+	}
+
+	if _, err := r.Add(1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	r.Complete(3)
+	if !r.Done() {
+		t.Fatal("Done() should be true once every seq below end has flushed")
+	}
+}
+
+func TestReceivedReportsFlushedAndBuffered(t *testing.T) {
+	r := New(1, 1024, func([]byte) {})
+
+	if _, err := r.Add(1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Add(3, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Received(1) {
+		t.Error("Received(1) = false, want true (already flushed)")
+	}
+	if !r.Received(3) {
+		t.Error("Received(3) = false, want true (buffered awaiting seq 2)")
+	}
+	if r.Received(2) {
+		t.Error("Received(2) = true, want false (never delivered)")
+	}
+}
@@ -0,0 +1,337 @@
+package libhttpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whitetig3r/httpc/pkg/libhttpc/congestion"
+	"github.com/whitetig3r/httpc/pkg/libhttpc/reassembly"
+)
+
+const (
+	// pTypeSyn and pTypeSynAck name the packet types handshake already uses
+	// as literals, for readability on the accept side.
+	pTypeSyn    = 2
+	pTypeSynAck = 3
+
+	// pTypeFin tears a connection down gracefully once its response has been
+	// fully delivered, the server-side analogue of pTypeEnd.
+	pTypeFin = 6
+)
+
+// Request is a request received by a Server, parsed from a reassembled UDP
+// request stream the way FromString parses a response.
+type Request struct {
+	Method   string
+	URI      string
+	Protocol string
+	Headers  string
+	Body     string
+}
+
+// parseRequest parses the raw text of a GET/POST request as built by
+// UDPGet/UDPPost ("METHOD uri protocol\r\nheaders\r\n\r\nbody") into a
+// Request.
+func parseRequest(raw string) (Request, error) {
+	parts := strings.SplitN(raw, CRLF+CRLF, 2)
+	body := BlankString
+	if len(parts) == 2 {
+		body = parts[1]
+	}
+
+	lines := strings.Split(parts[0], "\n")
+	requestLine := strings.Split(strings.TrimSuffix(lines[0], "\r"), " ")
+	if len(requestLine) < 3 {
+		return Request{}, errors.New("libhttpc: malformed request line")
+	}
+
+	return Request{
+		Method:   requestLine[0],
+		URI:      requestLine[1],
+		Protocol: requestLine[2],
+		Headers:  strings.Join(lines[1:], "\n"),
+		Body:     body,
+	}, nil
+}
+
+// String renders r the way a Client expects to parse it back with
+// FromString.
+func (r Response) String() string {
+	return fmt.Sprintf("%s %d\n%s%s%s", r.Protocol, r.StatusCode, r.Headers, CRLF+CRLF, r.Body)
+}
+
+// Server accepts SYN packets on a net.PacketConn, completes the 3-way
+// handshake per client 5-tuple, and demultiplexes subsequent packets into
+// per-connection goroutines. Each connection reuses the same
+// congestion.Controller, SelectiveRepeat and reassembly.Reassembler types
+// Client uses, so the transport behaves identically in both directions.
+type Server struct {
+	conn    net.PacketConn
+	opts    Options
+	handler func(Request) Response
+
+	mu    sync.Mutex
+	conns map[string]*serverConn
+}
+
+// NewServer creates a Server that accepts connections over conn.
+func NewServer(conn net.PacketConn, opts Options) *Server {
+	return &Server{
+		conn:  conn,
+		opts:  opts,
+		conns: make(map[string]*serverConn),
+	}
+}
+
+// ListenAndServe opens a UDP socket on addr and serves handler with
+// DefaultOptions until an unrecoverable read error occurs.
+func ListenAndServe(addr string, handler func(Request) Response) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return NewServer(conn, DefaultOptions()).Serve(handler)
+}
+
+// Serve reads packets from s's PacketConn, completing a handshake and
+// spawning a connection goroutine for each new peer, and routing every
+// later packet from a known peer to its own goroutine.
+func (s *Server) Serve(handler func(Request) Response) error {
+	s.handler = handler
+
+	for {
+		readBuf := make([]byte, maxPacketSize)
+		n, peerAddr, err := s.conn.ReadFrom(readBuf)
+		if err != nil {
+			return err
+		}
+		packet := ParsePacket(readBuf[:n])
+
+		key := peerAddr.String()
+		s.mu.Lock()
+		conn, ok := s.conns[key]
+		if !ok {
+			if packet.pType[0] != pTypeSyn {
+				// Stray packet for a connection we don't know about; drop it.
+				s.mu.Unlock()
+				continue
+			}
+			conn = newServerConn(s, peerAddr)
+			s.conns[key] = conn
+			go conn.run()
+		}
+		s.mu.Unlock()
+
+		conn.in <- packet
+	}
+}
+
+func (s *Server) forget(key string) {
+	s.mu.Lock()
+	delete(s.conns, key)
+	s.mu.Unlock()
+}
+
+// serverConn is the per-peer state for one reliable-UDP connection: a read
+// pump fed by Server.Serve's demultiplexing, a handshake, a reassembled
+// request, and a windowed response send.
+type serverConn struct {
+	server   *Server
+	peerAddr net.Addr
+	in       chan UDPPacket
+}
+
+func newServerConn(s *Server, peerAddr net.Addr) *serverConn {
+	return &serverConn{
+		server:   s,
+		peerAddr: peerAddr,
+		in:       make(chan UDPPacket, 32),
+	}
+}
+
+// peerURL wraps peerAddr as a *url.URL so serverConn can reuse
+// makePacket/getDataPacketBytes, which take a parsed URL purely to read its
+// Host.
+func (sc *serverConn) peerURL() *url.URL {
+	return &url.URL{Host: sc.peerAddr.String()}
+}
+
+func (sc *serverConn) run() {
+	defer sc.server.forget(sc.peerAddr.String())
+
+	request, err := sc.accept()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	response := sc.server.handler(request)
+	if err := sc.sendResponse(response); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// accept completes the 3-way handshake for the SYN that caused this
+// serverConn to be created, then reassembles the request that follows it.
+func (sc *serverConn) accept() (Request, error) {
+	synPacket := <-sc.in
+	seqInit := binary.BigEndian.Uint32(synPacket.seqNo)
+
+	synAck, err := makePacket(pTypeSynAck, seqInit+1, sc.peerURL(), "")
+	if err != nil {
+		return Request{}, err
+	}
+	if _, err := sc.server.conn.WriteTo(getBytesFromPacket(synAck), sc.peerAddr); err != nil {
+		return Request{}, err
+	}
+
+handshakeLoop:
+	for {
+		select {
+		case packet := <-sc.in:
+			if packet.pType[0] == pTypeSyn {
+				// Retransmitted SYN while our SYN-ACK is in flight; ignore.
+				continue
+			}
+			if packet.pType[0] != 1 || binary.BigEndian.Uint32(packet.seqNo) != seqInit+2 {
+				return Request{}, errors.New("libhttpc: handshake ACK mismatch")
+			}
+			break handshakeLoop
+		case <-time.After(sc.server.opts.HandshakeTimeout):
+			return Request{}, errors.New("libhttpc: handshake timed out")
+		}
+	}
+
+	return sc.receiveRequest()
+}
+
+// receiveRequest reassembles the data packets that follow a completed
+// handshake, ACKing each one with a cumulative sequence number plus a SACK
+// bitmap, until an explicit pTypeEnd packet closes the stream. The client
+// sends that pTypeEnd packet through its own Selective-Repeat ARQ (see
+// UDPGet/UDPPost), so it must be ACKed by its own sequence number, same as
+// any other packet the client is tracking as in flight.
+func (sc *serverConn) receiveRequest() (Request, error) {
+	var body bytes.Buffer
+	reassembler := reassembly.New(4, sc.server.opts.MaxBufferedBytes, func(fragment []byte) {
+		body.Write(fragment)
+	})
+
+	for {
+		var packet UDPPacket
+		select {
+		case packet = <-sc.in:
+		case <-time.After(sc.server.opts.ReadTimeout):
+			return Request{}, errors.New("libhttpc: timed out waiting for request data")
+		}
+
+		seq := binary.BigEndian.Uint32(packet.seqNo)
+		switch packet.pType[0] {
+		case 0:
+			if _, err := reassembler.Add(seq, packet.payload); err != nil {
+				return Request{}, err
+			}
+		case pTypeEnd:
+			reassembler.Complete(seq)
+		default:
+			continue
+		}
+
+		ackSeq := reassembler.NextExpected() - 1
+		if packet.pType[0] == pTypeEnd {
+			ackSeq = seq
+		}
+		ackPacket, err := makePacket(1, ackSeq, sc.peerURL(), string(encodeSACK(ackSeq, reassembler.Received)))
+		if err != nil {
+			fmt.Println(err)
+		} else if _, writeErr := sc.server.conn.WriteTo(getBytesFromPacket(ackPacket), sc.peerAddr); writeErr != nil {
+			fmt.Println("Timeout writing ACK!")
+		}
+
+		if reassembler.Done() {
+			return parseRequest(body.String())
+		}
+	}
+}
+
+// sendResponse sends response as a windowed, congestion-controlled stream of
+// data packets followed by a pTypeEnd marker, waits for every packet to be
+// ACKed via the same Selective-Repeat ARQ Client uses, and closes the
+// connection with a pTypeFin once delivery is confirmed.
+func (sc *serverConn) sendResponse(response Response) error {
+	peerURL := sc.peerURL()
+	packets, numPackets, err := getDataPacketBytes(1, peerURL, response.String())
+	if err != nil {
+		return err
+	}
+	endSeq := uint32(numPackets + 1)
+	endPacket, err := makePacket(pTypeEnd, endSeq, peerURL, "")
+	if err != nil {
+		return err
+	}
+	endPacketBytes := getBytesFromPacket(endPacket)
+
+	cc := congestion.New(sc.server.opts.Strategy)
+	sr := NewSelectiveRepeat(cc, func(seq uint32, pktBytes []byte) {
+		if _, writeErr := sc.server.conn.WriteTo(pktBytes, sc.peerAddr); writeErr != nil {
+			fmt.Println(writeErr)
+		}
+	})
+
+	// windowed sender: gate each send on the congestion window so we never
+	// have more than cwnd bytes in flight. This runs concurrently with the
+	// ACK/NAK loop below -- cwnd starts at 1 MSS and only grows once those
+	// are processed there, so reserving synchronously here would deadlock on
+	// the first packet that doesn't fit in the still-unexpanded window. The
+	// stream closes with a pTypeEnd marker sent through sr like any other
+	// packet, so a lost end marker retransmits instead of leaving the client
+	// hanging forever.
+	allSent := make(chan struct{})
+	go func() {
+		for i, packetBytes := range packets {
+			seq := uint32(1 + i)
+			cc.Reserve(len(packetBytes))
+			sr.Send(seq, packetBytes)
+		}
+		cc.Reserve(len(endPacketBytes))
+		sr.Send(endSeq, endPacketBytes)
+		close(allSent)
+	}()
+
+	// Outstanding() is also 0 before the goroutine above sends its first
+	// packet, so delivery is only confirmed once every packet has been sent.
+	sending := allSent
+	for sending != nil || sr.Outstanding() > 0 {
+		select {
+		case <-sending:
+			sending = nil
+		case packet := <-sc.in:
+			switch packet.pType[0] {
+			case 1:
+				sr.AckSACK(binary.BigEndian.Uint32(packet.seqNo), packet.payload)
+			case 4:
+				sr.Nak(binary.BigEndian.Uint32(packet.seqNo))
+			}
+		case failErr := <-sr.Failed():
+			return failErr
+		case <-time.After(sc.server.opts.ReadTimeout):
+			return errMaxRetriesExceeded
+		}
+	}
+
+	finPacket, err := makePacket(pTypeFin, endSeq, peerURL, "")
+	if err != nil {
+		return err
+	}
+	_, writeErr := sc.server.conn.WriteTo(getBytesFromPacket(finPacket), sc.peerAddr)
+	return writeErr
+}